@@ -0,0 +1,54 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelPropagator carries W3C traceparent/tracestate headers across Connect
+// calls so that spans started on one side of an RPC are linked to spans
+// started on the other.
+var otelPropagator = propagation.TraceContext{}
+
+// injectTraceContext writes the span in ctx, if any, into header as W3C
+// traceparent/tracestate headers.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	otelPropagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// extractTraceContext reads W3C traceparent/tracestate headers from header
+// and returns a context carrying the described remote span, if present.
+func extractTraceContext(ctx context.Context, header http.Header) context.Context {
+	return otelPropagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// exemplarLabels returns the {trace_id, span_id} labels for the span active
+// in ctx, or nil if there is none.
+func exemplarLabels(ctx context.Context) prometheus.Labels {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return nil
+	}
+	return prometheus.Labels{
+		"trace_id": spanCtx.TraceID().String(),
+		"span_id":  spanCtx.SpanID().String(),
+	}
+}
+
+// observeDuration records seconds on obs, attaching a trace exemplar derived
+// from ctx when withExemplars is enabled and a span is active.
+func observeDuration(ctx context.Context, obs prometheus.Observer, seconds float64, withExemplars bool) {
+	if withExemplars {
+		if labels := exemplarLabels(ctx); labels != nil {
+			if eo, ok := obs.(prometheus.ExemplarObserver); ok {
+				eo.ObserveWithExemplar(seconds, labels)
+				return
+			}
+		}
+	}
+	obs.Observe(seconds)
+}
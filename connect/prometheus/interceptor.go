@@ -2,16 +2,24 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/proto"
 )
 
 type Interceptor struct {
+	cfg *config
+
 	clientStarted  *prometheus.CounterVec
 	clientHandled  *prometheus.CounterVec
 	clientDuration *prometheus.HistogramVec
@@ -19,25 +27,46 @@ type Interceptor struct {
 	serverStarted  *prometheus.CounterVec
 	serverHandled  *prometheus.CounterVec
 	serverDuration *prometheus.HistogramVec
+
+	clientMsgSent     *prometheus.CounterVec
+	clientMsgReceived *prometheus.CounterVec
+	serverMsgSent     *prometheus.CounterVec
+	serverMsgReceived *prometheus.CounterVec
+
+	clientRequestSize  *prometheus.HistogramVec
+	clientResponseSize *prometheus.HistogramVec
+	serverRequestSize  *prometheus.HistogramVec
+	serverResponseSize *prometheus.HistogramVec
 }
 
-func NewInterceptor(reg prometheus.Registerer) *Interceptor {
+func NewInterceptor(reg prometheus.Registerer, opts ...Option) *Interceptor {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	labelCode := "grpc_code"
 	labelMethod := "grpc_method"
 	labelService := "grpc_service"
 	labelType := "grpc_type"
 
 	clientStarted := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
-		Name: "grpc_client_started_total",
-		Help: "Total number of RPCs started on the client.",
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_started_total",
+		Help:        "Total number of RPCs started on the client.",
+		ConstLabels: cfg.constLabels,
 	}, []string{
 		labelMethod,
 		labelService,
 		labelType,
 	})
 	clientHandled := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
-		Name: "grpc_client_handled_total",
-		Help: "Total number of RPCs completed by the client, regardless of success or failure.",
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_handled_total",
+		Help:        "Total number of RPCs completed by the client, regardless of success or failure.",
+		ConstLabels: cfg.constLabels,
 	}, []string{
 		labelCode,
 		labelMethod,
@@ -45,9 +74,12 @@ func NewInterceptor(reg prometheus.Registerer) *Interceptor {
 		labelType,
 	})
 	clientDuration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "grpc_client_handling_seconds_bucket",
-		Help:    "Histogram of response latency (seconds) of gRPC that had been application-level handled by the server.",
-		Buckets: prometheus.DefBuckets,
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_handling_seconds",
+		Help:        "Histogram of response latency (seconds) of gRPC that had been application-level handled by the server.",
+		Buckets:     cfg.buckets,
+		ConstLabels: cfg.constLabels,
 	}, []string{
 		labelMethod,
 		labelService,
@@ -55,16 +87,22 @@ func NewInterceptor(reg prometheus.Registerer) *Interceptor {
 	})
 
 	serverStarted := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
-		Name: "grpc_server_started_total",
-		Help: "Total number of RPCs started on the server.",
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_started_total",
+		Help:        "Total number of RPCs started on the server.",
+		ConstLabels: cfg.constLabels,
 	}, []string{
 		labelMethod,
 		labelService,
 		labelType,
 	})
 	serverHandled := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
-		Name: "grpc_server_handled_total",
-		Help: "The amount of requests handled per connect service and method by code",
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_handled_total",
+		Help:        "The amount of requests handled per connect service and method by code",
+		ConstLabels: cfg.constLabels,
 	}, []string{
 		labelCode,
 		labelMethod,
@@ -72,9 +110,106 @@ func NewInterceptor(reg prometheus.Registerer) *Interceptor {
 		labelType,
 	})
 	serverDuration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "grpc_server_handling_seconds_bucket",
-		Help:    "Histogram of response latency (seconds) of gRPC that had been application-level handled by the server.",
-		Buckets: prometheus.DefBuckets,
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_handling_seconds",
+		Help:        "Histogram of response latency (seconds) of gRPC that had been application-level handled by the server.",
+		Buckets:     cfg.buckets,
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+
+	clientMsgSent := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_msg_sent_total",
+		Help:        "Total number of gRPC stream messages sent by the client.",
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+	clientMsgReceived := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_msg_received_total",
+		Help:        "Total number of gRPC stream messages received by the client.",
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+	serverMsgSent := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_msg_sent_total",
+		Help:        "Total number of gRPC stream messages sent by the server.",
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+	serverMsgReceived := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_msg_received_total",
+		Help:        "Total number of gRPC stream messages received by the server.",
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+
+	clientRequestSize := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_request_size_bytes",
+		Help:        "Histogram of the size of request payloads (in bytes) sent by the client.",
+		Buckets:     cfg.sizeBuckets,
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+	clientResponseSize := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_client_response_size_bytes",
+		Help:        "Histogram of the size of response payloads (in bytes) received by the client.",
+		Buckets:     cfg.sizeBuckets,
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+	serverRequestSize := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_request_size_bytes",
+		Help:        "Histogram of the size of request payloads (in bytes) received by the server.",
+		Buckets:     cfg.sizeBuckets,
+		ConstLabels: cfg.constLabels,
+	}, []string{
+		labelMethod,
+		labelService,
+		labelType,
+	})
+	serverResponseSize := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   cfg.namespace,
+		Subsystem:   cfg.subsystem,
+		Name:        "grpc_server_response_size_bytes",
+		Help:        "Histogram of the size of response payloads (in bytes) sent by the server.",
+		Buckets:     cfg.sizeBuckets,
+		ConstLabels: cfg.constLabels,
 	}, []string{
 		labelMethod,
 		labelService,
@@ -82,6 +217,8 @@ func NewInterceptor(reg prometheus.Registerer) *Interceptor {
 	})
 
 	return &Interceptor{
+		cfg: cfg,
+
 		clientHandled:  clientHandled,
 		clientStarted:  clientStarted,
 		clientDuration: clientDuration,
@@ -89,6 +226,16 @@ func NewInterceptor(reg prometheus.Registerer) *Interceptor {
 		serverStarted:  serverStarted,
 		serverHandled:  serverHandled,
 		serverDuration: serverDuration,
+
+		clientMsgSent:     clientMsgSent,
+		clientMsgReceived: clientMsgReceived,
+		serverMsgSent:     serverMsgSent,
+		serverMsgReceived: serverMsgReceived,
+
+		clientRequestSize:  clientRequestSize,
+		clientResponseSize: clientResponseSize,
+		serverRequestSize:  serverRequestSize,
+		serverResponseSize: serverResponseSize,
 	}
 }
 
@@ -106,51 +253,99 @@ func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
 		service, method := procedure[1], procedure[2]
 
 		if spec.IsClient {
+			injectTraceContext(ctx, req.Header())
+
 			i.clientStarted.WithLabelValues(
 				method,
 				service,
 				streamType(spec.StreamType),
-			)
+			).Inc()
+			i.clientRequestSize.WithLabelValues(
+				method,
+				service,
+				streamType(spec.StreamType),
+			).Observe(messageSize(req.Any()))
 		} else {
+			ctx = extractTraceContext(ctx, req.Header())
+
 			i.serverStarted.WithLabelValues(
 				method,
 				service,
 				streamType(spec.StreamType),
 			).Inc()
+			i.serverRequestSize.WithLabelValues(
+				method,
+				service,
+				streamType(spec.StreamType),
+			).Observe(messageSize(req.Any()))
 		}
 
 		resp, err := next(ctx, req)
 
 		if spec.IsClient {
-			i.clientDuration.WithLabelValues(
-				method,
-				service,
-				streamType(spec.StreamType),
-			).Observe(time.Since(start).Seconds())
+			observeDuration(
+				ctx,
+				i.clientDuration.WithLabelValues(
+					method,
+					service,
+					streamType(spec.StreamType),
+				),
+				time.Since(start).Seconds(),
+				i.cfg.exemplars,
+			)
 			i.clientHandled.WithLabelValues(
 				code(err),
 				method,
 				service,
 				streamType(spec.StreamType),
 			).Inc()
+			if resp != nil {
+				i.clientResponseSize.WithLabelValues(
+					method,
+					service,
+					streamType(spec.StreamType),
+				).Observe(messageSize(resp.Any()))
+			}
 		} else { // server
-			i.serverDuration.WithLabelValues(
-				method,
-				service,
-				streamType(spec.StreamType),
-			).Observe(time.Since(start).Seconds())
+			observeDuration(
+				ctx,
+				i.serverDuration.WithLabelValues(
+					method,
+					service,
+					streamType(spec.StreamType),
+				),
+				time.Since(start).Seconds(),
+				i.cfg.exemplars,
+			)
 			i.serverHandled.WithLabelValues(
 				code(err),
 				method,
 				service,
 				streamType(spec.StreamType),
 			).Inc()
+			if resp != nil {
+				i.serverResponseSize.WithLabelValues(
+					method,
+					service,
+					streamType(spec.StreamType),
+				).Observe(messageSize(resp.Any()))
+			}
 		}
 
 		return resp, err
 	}
 }
 
+// messageSize returns the wire size of msg in bytes if it is a proto.Message,
+// and 0 otherwise.
+func messageSize(msg any) float64 {
+	pm, ok := msg.(proto.Message)
+	if !ok {
+		return 0
+	}
+	return float64(proto.Size(pm))
+}
+
 func code(err error) string {
 	if err == nil {
 		return "ok"
@@ -174,11 +369,181 @@ func streamType(t connect.StreamType) string {
 }
 
 func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
-	// TODO implement me
-	panic("implement me")
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		procedure := strings.Split(spec.Procedure, "/")
+		if len(procedure) != 3 {
+			return &errorStreamingClientConn{
+				spec: spec,
+				err: connect.NewError(
+					connect.CodeInternal,
+					fmt.Errorf("procedure in prometheus interceptor malformed: %s", spec.Procedure),
+				),
+			}
+		}
+		service, method := procedure[1], procedure[2]
+		typ := streamType(spec.StreamType)
+
+		conn := next(ctx, spec)
+
+		i.clientStarted.WithLabelValues(method, service, typ).Inc()
+		injectTraceContext(ctx, conn.RequestHeader())
+
+		return &monitoredClientConn{
+			StreamingClientConn: conn,
+			i:                   i,
+			ctx:                 ctx,
+			start:               time.Now(),
+			method:              method,
+			service:             service,
+			typ:                 typ,
+		}
+	}
 }
 
 func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
-	// TODO implement me
-	panic("implement me")
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		start := time.Now()
+		spec := conn.Spec()
+		procedure := strings.Split(spec.Procedure, "/")
+		if len(procedure) != 3 {
+			return connect.NewError(
+				connect.CodeInternal,
+				fmt.Errorf("procedure in prometheus interceptor malformed: %s", spec.Procedure),
+			)
+		}
+		service, method := procedure[1], procedure[2]
+		typ := streamType(spec.StreamType)
+
+		i.serverStarted.WithLabelValues(method, service, typ).Inc()
+		ctx = extractTraceContext(ctx, conn.RequestHeader())
+
+		wrapped := &monitoredHandlerConn{
+			StreamingHandlerConn: conn,
+			i:                    i,
+			method:               method,
+			service:              service,
+			typ:                  typ,
+		}
+
+		err := next(ctx, wrapped)
+
+		observeDuration(ctx, i.serverDuration.WithLabelValues(method, service, typ), time.Since(start).Seconds(), i.cfg.exemplars)
+		i.serverHandled.WithLabelValues(code(err), method, service, typ).Inc()
+		i.serverRequestSize.WithLabelValues(method, service, typ).Observe(float64(atomic.LoadInt64(&wrapped.reqSize)))
+		i.serverResponseSize.WithLabelValues(method, service, typ).Observe(float64(atomic.LoadInt64(&wrapped.respSize)))
+
+		return err
+	}
+}
+
+// errorStreamingClientConn stands in for a connect.StreamingClientConn when
+// the procedure could not be parsed, making Send and Receive fail with err
+// instead of silently skipping instrumentation. Because the procedure is
+// malformed, the underlying stream is never established by calling next.
+type errorStreamingClientConn struct {
+	spec connect.Spec
+	err  error
+}
+
+func (c *errorStreamingClientConn) Spec() connect.Spec           { return c.spec }
+func (c *errorStreamingClientConn) Peer() connect.Peer           { return connect.Peer{} }
+func (c *errorStreamingClientConn) Send(any) error               { return c.err }
+func (c *errorStreamingClientConn) RequestHeader() http.Header   { return http.Header{} }
+func (c *errorStreamingClientConn) CloseRequest() error          { return c.err }
+func (c *errorStreamingClientConn) Receive(any) error            { return c.err }
+func (c *errorStreamingClientConn) ResponseHeader() http.Header  { return http.Header{} }
+func (c *errorStreamingClientConn) ResponseTrailer() http.Header { return http.Header{} }
+func (c *errorStreamingClientConn) CloseResponse() error         { return c.err }
+
+// monitoredClientConn wraps a connect.StreamingClientConn to count individual
+// messages sent and received, and to report handled/duration metrics once the
+// stream reaches a terminal state, mirroring how the unary interceptor reports
+// those same metrics.
+type monitoredClientConn struct {
+	connect.StreamingClientConn
+
+	i       *Interceptor
+	ctx     context.Context
+	start   time.Time
+	method  string
+	service string
+	typ     string
+
+	reqSize  int64
+	respSize int64
+
+	finishOnce sync.Once
+}
+
+func (c *monitoredClientConn) Send(msg any) error {
+	err := c.StreamingClientConn.Send(msg)
+	if err == nil {
+		c.i.clientMsgSent.WithLabelValues(c.method, c.service, c.typ).Inc()
+		atomic.AddInt64(&c.reqSize, int64(messageSize(msg)))
+	}
+	return err
+}
+
+func (c *monitoredClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	switch {
+	case err == nil:
+		c.i.clientMsgReceived.WithLabelValues(c.method, c.service, c.typ).Inc()
+		atomic.AddInt64(&c.respSize, int64(messageSize(msg)))
+	case errors.Is(err, io.EOF):
+		c.finish(nil)
+	default:
+		c.finish(err)
+	}
+	return err
+}
+
+func (c *monitoredClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.finish(err)
+	return err
+}
+
+// finish reports the duration, handled and payload size metrics exactly
+// once, the first time the stream ends, either because Receive saw
+// io.EOF/an error or because the caller closed the response.
+func (c *monitoredClientConn) finish(err error) {
+	c.finishOnce.Do(func() {
+		observeDuration(c.ctx, c.i.clientDuration.WithLabelValues(c.method, c.service, c.typ), time.Since(c.start).Seconds(), c.i.cfg.exemplars)
+		c.i.clientHandled.WithLabelValues(code(err), c.method, c.service, c.typ).Inc()
+		c.i.clientRequestSize.WithLabelValues(c.method, c.service, c.typ).Observe(float64(atomic.LoadInt64(&c.reqSize)))
+		c.i.clientResponseSize.WithLabelValues(c.method, c.service, c.typ).Observe(float64(atomic.LoadInt64(&c.respSize)))
+	})
+}
+
+// monitoredHandlerConn wraps a connect.StreamingHandlerConn to count
+// individual messages sent and received on the server side of a stream.
+type monitoredHandlerConn struct {
+	connect.StreamingHandlerConn
+
+	i       *Interceptor
+	method  string
+	service string
+	typ     string
+
+	reqSize  int64
+	respSize int64
+}
+
+func (c *monitoredHandlerConn) Send(msg any) error {
+	err := c.StreamingHandlerConn.Send(msg)
+	if err == nil {
+		c.i.serverMsgSent.WithLabelValues(c.method, c.service, c.typ).Inc()
+		atomic.AddInt64(&c.respSize, int64(messageSize(msg)))
+	}
+	return err
+}
+
+func (c *monitoredHandlerConn) Receive(msg any) error {
+	err := c.StreamingHandlerConn.Receive(msg)
+	if err == nil {
+		c.i.serverMsgReceived.WithLabelValues(c.method, c.service, c.typ).Inc()
+		atomic.AddInt64(&c.reqSize, int64(messageSize(msg)))
+	}
+	return err
 }
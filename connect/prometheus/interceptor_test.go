@@ -0,0 +1,591 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeAnyRequest implements connect.AnyRequest by embedding the interface
+// (to satisfy its unexported methods) and overriding the bits the
+// interceptor actually reads.
+type fakeAnyRequest struct {
+	connect.AnyRequest
+	spec connect.Spec
+	hdr  http.Header
+	msg  any
+}
+
+func (f *fakeAnyRequest) Spec() connect.Spec  { return f.spec }
+func (f *fakeAnyRequest) Header() http.Header { return f.hdr }
+func (f *fakeAnyRequest) Any() any            { return f.msg }
+
+// fakeAnyResponse implements connect.AnyResponse the same way.
+type fakeAnyResponse struct {
+	connect.AnyResponse
+	msg any
+}
+
+func (f *fakeAnyResponse) Any() any { return f.msg }
+
+func counterValue(t *testing.T, reg *prometheus.Registry, name string) (float64, bool) {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		var sum float64
+		for _, m := range mf.Metric {
+			if c := m.GetCounter(); c != nil {
+				sum += c.GetValue()
+			}
+			if h := m.GetHistogram(); h != nil {
+				sum += float64(h.GetSampleCount())
+			}
+		}
+		return sum, true
+	}
+	return 0, false
+}
+
+func mustCounter(t *testing.T, reg *prometheus.Registry, name string, want float64) {
+	t.Helper()
+	got, ok := counterValue(t, reg, name)
+	if !ok {
+		t.Fatalf("metric family %s not found", name)
+	}
+	if got != want {
+		t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+}
+
+// histogramSample returns the sample count and sum observed on the single
+// histogram in the named metric family.
+func histogramSample(t *testing.T, reg *prometheus.Registry, name string) (count uint64, sum float64) {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.Metric {
+			h := m.GetHistogram()
+			if h == nil {
+				continue
+			}
+			count += h.GetSampleCount()
+			sum += h.GetSampleSum()
+		}
+		return count, sum
+	}
+	t.Fatalf("metric family %s not found", name)
+	return 0, 0
+}
+
+func mustHistogram(t *testing.T, reg *prometheus.Registry, name string, wantCount uint64, wantSum float64) {
+	t.Helper()
+	count, sum := histogramSample(t, reg, name)
+	if count != wantCount {
+		t.Fatalf("%s sample count = %d, want %d", name, count, wantCount)
+	}
+	if sum != wantSum {
+		t.Fatalf("%s sample sum = %v, want %v", name, sum, wantSum)
+	}
+}
+
+func TestOptionsConfigureRegisteredMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	buckets := []float64{0.001, 0.005, 0.01}
+	sizeBuckets := []float64{16, 256, 4096}
+	i := NewInterceptor(reg,
+		WithNamespace("pyrra"),
+		WithSubsystem("api"),
+		WithHistogramBuckets(buckets),
+		WithSizeBuckets(sizeBuckets),
+		WithConstLabels(prometheus.Labels{"env": "test"}),
+	)
+
+	unary := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return &fakeAnyResponse{}, nil
+	})
+	req := &fakeAnyRequest{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:  http.Header{},
+	}
+	if _, err := unary(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var started, duration, reqSize *dto.MetricFamily
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "pyrra_api_grpc_client_started_total":
+			started = mf
+		case "pyrra_api_grpc_client_handling_seconds":
+			duration = mf
+		case "pyrra_api_grpc_client_request_size_bytes":
+			reqSize = mf
+		}
+	}
+	if started == nil {
+		t.Fatal("expected metric family pyrra_api_grpc_client_started_total, found none")
+	}
+
+	gotLabels := make(map[string]string)
+	for _, l := range started.Metric[0].GetLabel() {
+		gotLabels[l.GetName()] = l.GetValue()
+	}
+	if gotLabels["env"] != "test" {
+		t.Fatalf("const label env = %q, want %q", gotLabels["env"], "test")
+	}
+
+	if duration == nil {
+		t.Fatal("expected metric family pyrra_api_grpc_client_handling_seconds, found none")
+	}
+	gotBuckets := duration.Metric[0].GetHistogram().GetBucket()
+	if len(gotBuckets) != len(buckets) {
+		t.Fatalf("duration buckets = %v, want %v", gotBuckets, buckets)
+	}
+	for idx, b := range gotBuckets {
+		if b.GetUpperBound() != buckets[idx] {
+			t.Fatalf("duration bucket[%d] = %v, want %v", idx, b.GetUpperBound(), buckets[idx])
+		}
+	}
+
+	if reqSize == nil {
+		t.Fatal("expected metric family pyrra_api_grpc_client_request_size_bytes, found none")
+	}
+	gotSizeBuckets := reqSize.Metric[0].GetHistogram().GetBucket()
+	if len(gotSizeBuckets) != len(sizeBuckets) {
+		t.Fatalf("size buckets = %v, want %v", gotSizeBuckets, sizeBuckets)
+	}
+	for idx, b := range gotSizeBuckets {
+		if b.GetUpperBound() != sizeBuckets[idx] {
+			t.Fatalf("size bucket[%d] = %v, want %v", idx, b.GetUpperBound(), sizeBuckets[idx])
+		}
+	}
+}
+
+func TestWrapUnaryCountsClientAndServer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	unary := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return &fakeAnyResponse{}, nil
+	})
+
+	clientReq := &fakeAnyRequest{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:  http.Header{},
+	}
+	if _, err := unary(context.Background(), clientReq); err != nil {
+		t.Fatal(err)
+	}
+
+	serverReq := &fakeAnyRequest{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: false},
+		hdr:  http.Header{},
+	}
+	if _, err := unary(context.Background(), serverReq); err != nil {
+		t.Fatal(err)
+	}
+
+	mustCounter(t, reg, "grpc_client_started_total", 1)
+	mustCounter(t, reg, "grpc_client_handled_total", 1)
+	mustCounter(t, reg, "grpc_server_started_total", 1)
+	mustCounter(t, reg, "grpc_server_handled_total", 1)
+}
+
+func TestWrapUnaryMalformedProcedure(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	unary := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		t.Fatal("next should not be called for a malformed procedure")
+		return nil, nil
+	})
+
+	req := &fakeAnyRequest{
+		spec: connect.Spec{Procedure: "garbage"},
+		hdr:  http.Header{},
+	}
+	_, err := unary(context.Background(), req)
+	if connect.CodeOf(err) != connect.CodeInternal {
+		t.Fatalf("got error %v, want CodeInternal", err)
+	}
+}
+
+func TestWrapUnaryObservesPayloadSize(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	reqMsg := wrapperspb.String("request")
+	respMsg := wrapperspb.String("response")
+
+	unary := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return &fakeAnyResponse{msg: respMsg}, nil
+	})
+
+	req := &fakeAnyRequest{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:  http.Header{},
+		msg:  reqMsg,
+	}
+	if _, err := unary(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	mustHistogram(t, reg, "grpc_client_request_size_bytes", 1, float64(proto.Size(reqMsg)))
+	mustHistogram(t, reg, "grpc_client_response_size_bytes", 1, float64(proto.Size(respMsg)))
+}
+
+// fakeStreamingClientConn is a minimal connect.StreamingClientConn that
+// yields a fixed sequence of messages before returning io.EOF.
+type fakeStreamingClientConn struct {
+	spec      connect.Spec
+	hdr       http.Header
+	toReceive int
+}
+
+func (c *fakeStreamingClientConn) Spec() connect.Spec           { return c.spec }
+func (c *fakeStreamingClientConn) Peer() connect.Peer           { return connect.Peer{} }
+func (c *fakeStreamingClientConn) Send(any) error               { return nil }
+func (c *fakeStreamingClientConn) RequestHeader() http.Header   { return c.hdr }
+func (c *fakeStreamingClientConn) CloseRequest() error          { return nil }
+func (c *fakeStreamingClientConn) ResponseHeader() http.Header  { return http.Header{} }
+func (c *fakeStreamingClientConn) ResponseTrailer() http.Header { return http.Header{} }
+func (c *fakeStreamingClientConn) CloseResponse() error         { return nil }
+
+func (c *fakeStreamingClientConn) Receive(any) error {
+	if c.toReceive <= 0 {
+		return io.EOF
+	}
+	c.toReceive--
+	return nil
+}
+
+func TestWrapStreamingClientCountsMessages(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	fake := &fakeStreamingClientConn{
+		spec:      connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:       http.Header{},
+		toReceive: 3,
+	}
+	streamFn := i.WrapStreamingClient(func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return fake
+	})
+
+	conn := streamFn(context.Background(), fake.spec)
+	for n := 0; n < 2; n++ {
+		if err := conn.Send(struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		if err := conn.Receive(struct{}{}); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+	}
+
+	mustCounter(t, reg, "grpc_client_started_total", 1)
+	mustCounter(t, reg, "grpc_client_msg_sent_total", 2)
+	mustCounter(t, reg, "grpc_client_msg_received_total", 3)
+	mustCounter(t, reg, "grpc_client_handled_total", 1)
+}
+
+func TestWrapStreamingClientAccumulatesPayloadSize(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	sent := []proto.Message{wrapperspb.String("a"), wrapperspb.String("bb")}
+	received := []proto.Message{wrapperspb.String("ccc")}
+
+	fake := &fakeStreamingClientConn{
+		spec:      connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:       http.Header{},
+		toReceive: len(received),
+	}
+	streamFn := i.WrapStreamingClient(func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return fake
+	})
+
+	conn := streamFn(context.Background(), fake.spec)
+	for _, msg := range sent {
+		if err := conn.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wantRespSize int
+	for _, msg := range received {
+		if err := conn.Receive(msg); err != nil {
+			t.Fatal(err)
+		}
+		wantRespSize += proto.Size(msg)
+	}
+	if err := conn.Receive(struct{}{}); !errors.Is(err, io.EOF) {
+		t.Fatalf("final Receive error = %v, want io.EOF", err)
+	}
+
+	wantReqSize := 0
+	for _, msg := range sent {
+		wantReqSize += proto.Size(msg)
+	}
+
+	mustHistogram(t, reg, "grpc_client_request_size_bytes", 1, float64(wantReqSize))
+	mustHistogram(t, reg, "grpc_client_response_size_bytes", 1, float64(wantRespSize))
+}
+
+func TestWrapStreamingClientMalformedProcedure(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	fake := &fakeStreamingClientConn{spec: connect.Spec{Procedure: "garbage"}, hdr: http.Header{}}
+	streamFn := i.WrapStreamingClient(func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return fake
+	})
+
+	conn := streamFn(context.Background(), fake.spec)
+	if err := conn.Send(struct{}{}); connect.CodeOf(err) != connect.CodeInternal {
+		t.Fatalf("Send error = %v, want CodeInternal", err)
+	}
+	if err := conn.Receive(struct{}{}); connect.CodeOf(err) != connect.CodeInternal {
+		t.Fatalf("Receive error = %v, want CodeInternal", err)
+	}
+}
+
+// fakeStreamingHandlerConn is a minimal connect.StreamingHandlerConn.
+type fakeStreamingHandlerConn struct {
+	spec connect.Spec
+	hdr  http.Header
+}
+
+func (c *fakeStreamingHandlerConn) Spec() connect.Spec           { return c.spec }
+func (c *fakeStreamingHandlerConn) Peer() connect.Peer           { return connect.Peer{} }
+func (c *fakeStreamingHandlerConn) Receive(any) error            { return nil }
+func (c *fakeStreamingHandlerConn) RequestHeader() http.Header   { return c.hdr }
+func (c *fakeStreamingHandlerConn) Send(any) error               { return nil }
+func (c *fakeStreamingHandlerConn) ResponseHeader() http.Header  { return http.Header{} }
+func (c *fakeStreamingHandlerConn) ResponseTrailer() http.Header { return http.Header{} }
+
+func TestWrapStreamingHandlerCountsMessages(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	fake := &fakeStreamingHandlerConn{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar"},
+		hdr:  http.Header{},
+	}
+
+	handlerFn := i.WrapStreamingHandler(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := conn.Receive(struct{}{}); err != nil {
+			return err
+		}
+		return conn.Send(struct{}{})
+	})
+
+	if err := handlerFn(context.Background(), fake); err != nil {
+		t.Fatal(err)
+	}
+
+	mustCounter(t, reg, "grpc_server_started_total", 1)
+	mustCounter(t, reg, "grpc_server_handled_total", 1)
+	mustCounter(t, reg, "grpc_server_msg_sent_total", 1)
+	mustCounter(t, reg, "grpc_server_msg_received_total", 1)
+}
+
+func TestWrapStreamingHandlerAccumulatesPayloadSize(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	fake := &fakeStreamingHandlerConn{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar"},
+		hdr:  http.Header{},
+	}
+
+	reqMsg := wrapperspb.String("request")
+	respMsg := wrapperspb.String("response")
+
+	handlerFn := i.WrapStreamingHandler(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := conn.Receive(reqMsg); err != nil {
+			return err
+		}
+		return conn.Send(respMsg)
+	})
+
+	if err := handlerFn(context.Background(), fake); err != nil {
+		t.Fatal(err)
+	}
+
+	mustHistogram(t, reg, "grpc_server_request_size_bytes", 1, float64(proto.Size(reqMsg)))
+	mustHistogram(t, reg, "grpc_server_response_size_bytes", 1, float64(proto.Size(respMsg)))
+}
+
+func TestWrapStreamingHandlerMalformedProcedure(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg)
+
+	fake := &fakeStreamingHandlerConn{spec: connect.Spec{Procedure: "garbage"}, hdr: http.Header{}}
+	handlerFn := i.WrapStreamingHandler(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		t.Fatal("next should not be called for a malformed procedure")
+		return nil
+	})
+
+	err := handlerFn(context.Background(), fake)
+	if connect.CodeOf(err) != connect.CodeInternal {
+		t.Fatalf("got error %v, want CodeInternal", err)
+	}
+}
+
+func testSpanContext() trace.SpanContext {
+	traceID, err := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	if err != nil {
+		panic(err)
+	}
+	spanID, err := trace.SpanIDFromHex("0102030405060708")
+	if err != nil {
+		panic(err)
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+}
+
+func TestTraceContextRoundTrips(t *testing.T) {
+	sc := testSpanContext()
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	hdr := http.Header{}
+	injectTraceContext(ctx, hdr)
+	if hdr.Get("traceparent") == "" {
+		t.Fatal("injectTraceContext did not write a traceparent header")
+	}
+
+	extracted := extractTraceContext(context.Background(), hdr)
+	got := trace.SpanContextFromContext(extracted)
+	if got.TraceID() != sc.TraceID() {
+		t.Fatalf("round-tripped trace ID = %s, want %s", got.TraceID(), sc.TraceID())
+	}
+	if got.SpanID() != sc.SpanID() {
+		t.Fatalf("round-tripped span ID = %s, want %s", got.SpanID(), sc.SpanID())
+	}
+}
+
+// findExemplar returns the first exemplar found on any bucket of the
+// histograms in the named metric family, or nil if there is none.
+func findExemplar(t *testing.T, reg *prometheus.Registry, name string) *dto.Exemplar {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.Metric {
+			for _, b := range m.GetHistogram().GetBucket() {
+				if ex := b.GetExemplar(); ex != nil {
+					return ex
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestWrapUnaryRecordsExemplar(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg, WithExemplars())
+
+	unary := i.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return &fakeAnyResponse{}, nil
+	})
+
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext())
+	req := &fakeAnyRequest{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:  http.Header{},
+	}
+	if _, err := unary(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	ex := findExemplar(t, reg, "grpc_client_handling_seconds")
+	if ex == nil {
+		t.Fatal("no exemplar recorded on grpc_client_handling_seconds")
+	}
+	labels := make(map[string]string)
+	for _, l := range ex.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	sc := testSpanContext()
+	if labels["trace_id"] != sc.TraceID().String() {
+		t.Fatalf("exemplar trace_id = %q, want %q", labels["trace_id"], sc.TraceID().String())
+	}
+	if labels["span_id"] != sc.SpanID().String() {
+		t.Fatalf("exemplar span_id = %q, want %q", labels["span_id"], sc.SpanID().String())
+	}
+}
+
+func TestWrapStreamingClientRecordsExemplar(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	i := NewInterceptor(reg, WithExemplars())
+
+	fake := &fakeStreamingClientConn{
+		spec: connect.Spec{Procedure: "/acme.foo.v1.FooService/Bar", IsClient: true},
+		hdr:  http.Header{},
+	}
+	streamFn := i.WrapStreamingClient(func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return fake
+	})
+
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext())
+	conn := streamFn(ctx, fake.spec)
+	if err := conn.Receive(struct{}{}); !errors.Is(err, io.EOF) {
+		t.Fatalf("Receive error = %v, want io.EOF", err)
+	}
+
+	ex := findExemplar(t, reg, "grpc_client_handling_seconds")
+	if ex == nil {
+		t.Fatal("no exemplar recorded on grpc_client_handling_seconds")
+	}
+	labels := make(map[string]string)
+	for _, l := range ex.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	sc := testSpanContext()
+	if labels["trace_id"] != sc.TraceID().String() {
+		t.Fatalf("exemplar trace_id = %q, want %q", labels["trace_id"], sc.TraceID().String())
+	}
+	if labels["span_id"] != sc.SpanID().String() {
+		t.Fatalf("exemplar span_id = %q, want %q", labels["span_id"], sc.SpanID().String())
+	}
+}
@@ -0,0 +1,75 @@
+package prometheus
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// config holds the values that can be tuned via Option. It is built up from
+// sane defaults and then mutated by whatever Option values are passed to
+// NewInterceptor.
+type config struct {
+	namespace   string
+	subsystem   string
+	buckets     []float64
+	sizeBuckets []float64
+	constLabels prometheus.Labels
+	exemplars   bool
+}
+
+func defaultConfig() *config {
+	return &config{
+		buckets:     prometheus.DefBuckets,
+		sizeBuckets: prometheus.ExponentialBuckets(100, 10, 7),
+	}
+}
+
+// Option configures the metrics registered by NewInterceptor.
+type Option func(*config)
+
+// WithNamespace sets the Prometheus namespace prefixed to every metric
+// registered by the interceptor, letting callers distinguish Pyrra's
+// connect traffic from other gRPC servers in the same registry.
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithSubsystem sets the Prometheus subsystem prefixed to every metric
+// registered by the interceptor.
+func WithSubsystem(subsystem string) Option {
+	return func(c *config) {
+		c.subsystem = subsystem
+	}
+}
+
+// WithHistogramBuckets overrides the default bucket boundaries
+// (prometheus.DefBuckets) used for the duration histograms. Callers with
+// tighter SLOs should supply buckets that cover their own latency range.
+func WithHistogramBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// WithSizeBuckets overrides the default bucket boundaries used for the
+// request/response payload size histograms.
+func WithSizeBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.sizeBuckets = buckets
+	}
+}
+
+// WithConstLabels attaches the given labels to every metric registered by
+// the interceptor.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(c *config) {
+		c.constLabels = labels
+	}
+}
+
+// WithExemplars enables attaching Prometheus exemplars to the duration
+// histograms whenever a trace span is active in the request context.
+func WithExemplars() Option {
+	return func(c *config) {
+		c.exemplars = true
+	}
+}